@@ -2,11 +2,11 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -17,6 +17,7 @@ import (
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/alecthomas/kingpin"
+	"github.com/pmezard/brestagenda/crawler"
 )
 
 type Event struct {
@@ -26,6 +27,42 @@ type Event struct {
 	Link     string
 	Start    time.Time
 	End      time.Time
+
+	// Venue, Price and FullDesc are optionally scraped from the event's
+	// detail page when crawl is run with --enrich.
+	Venue    string `json:",omitempty"`
+	Price    string `json:",omitempty"`
+	FullDesc string `json:",omitempty"`
+
+	// ID is a stable identifier derived from Link and Start, used to merge
+	// successive crawls into the same store entry.
+	ID string
+	// FirstSeen is when this event was first crawled.
+	FirstSeen time.Time
+	// LastSeen is when this event was last seen in a crawl.
+	LastSeen time.Time
+	// Updated is when Title, Desc, Category, Start or End last changed.
+	Updated time.Time
+}
+
+// eventID computes the stable ID used to track an event across crawls.
+func eventID(ev *Event) string {
+	h := sha1.New()
+	io.WriteString(h, ev.Link)
+	io.WriteString(h, "|")
+	io.WriteString(h, ev.Start.Format(icsDateLayout))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// sameContent reports whether two events have the same user-visible
+// content, ignoring tracking timestamps.
+func sameContent(a, b *Event) bool {
+	return a.Title == b.Title &&
+		a.Desc == b.Desc &&
+		a.Category == b.Category &&
+		a.Link == b.Link &&
+		a.Start.Equal(b.Start) &&
+		a.End.Equal(b.End)
 }
 
 func extractEvents(doc *goquery.Document, baseUrl *url.URL) ([]Event, error) {
@@ -68,6 +105,55 @@ func extractEvents(doc *goquery.Document, baseUrl *url.URL) ([]Event, error) {
 	return events, nil
 }
 
+// parseDetail extracts the venue, price and full description from an
+// event's detail page, where listing pages only give a short teaser.
+func parseDetail(doc *goquery.Document) (venue, price, fullDesc string) {
+	venue = strings.TrimSpace(doc.Find("p[class~='lieu']").First().Text())
+	price = strings.TrimSpace(doc.Find("p[class~='tarif']").First().Text())
+	fullDesc = strings.TrimSpace(doc.Find("div[class~='texte']").First().Text())
+	return venue, price, fullDesc
+}
+
+// enrichEvents follows each event's detail page, concurrently through
+// fetcher and workers, and fills in Venue, Price and FullDesc in place.
+// Fetch errors are logged and otherwise ignored: enrichment is a bonus, not
+// something that should fail the whole crawl. stored is the previous
+// store's events, used to keep a detail page's last known Venue/Price/
+// FullDesc when the conditional GET comes back 304 Not Modified (the page
+// itself was not re-fetched, so there is nothing new to parse).
+func enrichEvents(fetcher *crawler.Fetcher, workers int, events []Event, stored []Event) {
+	prevByID := make(map[string]Event, len(stored))
+	for _, ev := range stored {
+		prevByID[ev.ID] = ev
+	}
+
+	links := make([]string, len(events))
+	for i := range events {
+		links[i] = events[i].Link
+	}
+	results := crawler.New(fetcher, workers).FetchAll(links)
+	for i := range results {
+		res := results[i]
+		if res.NotModified {
+			if prev, ok := prevByID[eventID(&events[i])]; ok {
+				events[i].Venue, events[i].Price, events[i].FullDesc =
+					prev.Venue, prev.Price, prev.FullDesc
+			}
+			continue
+		}
+		if res.Err != nil {
+			fmt.Println("enrich", res.URL, "failed:", res.Err)
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(res.Body))
+		if err != nil {
+			fmt.Println("enrich", res.URL, "failed:", err)
+			continue
+		}
+		events[i].Venue, events[i].Price, events[i].FullDesc = parseDetail(doc)
+	}
+}
+
 var ServerError = fmt.Errorf("server error")
 
 type Page struct {
@@ -75,36 +161,29 @@ type Page struct {
 	Next   string
 }
 
-func getPage(client *http.Client, url, base *url.URL, dumpDir string, pageNum int) (
+func getPage(fetcher *crawler.Fetcher, pageUrl, base *url.URL, dumpDir string, pageNum int) (
 	*Page, error) {
 
-	rsp, err := client.Get(url.String())
-	if err != nil {
-		return nil, err
-	}
-	defer rsp.Body.Close()
-	if rsp.StatusCode != 200 {
-		if rsp.StatusCode == 500 {
+	res := fetcher.FetchFresh(pageUrl.String())
+	if res.Err != nil {
+		if res.StatusCode == 500 {
 			// Cannot do anything about it, try to generate with what we got.
 			return nil, ServerError
 		}
-		return nil, fmt.Errorf("GET got %d", rsp.StatusCode)
+		if res.StatusCode != 0 {
+			return nil, fmt.Errorf("GET got %d", res.StatusCode)
+		}
+		return nil, res.Err
 	}
-	var r io.Reader = rsp.Body
 	if dumpDir != "" {
 		path := filepath.Join(dumpDir, fmt.Sprintf("%d.html", pageNum))
 		fmt.Println("writing", path)
-		data, err := ioutil.ReadAll(rsp.Body)
-		if err != nil {
-			return nil, err
-		}
-		err = ioutil.WriteFile(path, data, 0644)
+		err := ioutil.WriteFile(path, res.Body, 0644)
 		if err != nil {
 			return nil, err
 		}
-		r = bytes.NewReader(data)
 	}
-	doc, err := goquery.NewDocumentFromReader(r)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(res.Body))
 	if err != nil {
 		return nil, err
 	}
@@ -122,28 +201,43 @@ func getPage(client *http.Client, url, base *url.URL, dumpDir string, pageNum in
 }
 
 var (
-	crawlCmd     = app.Command("crawl", "crawl brest.fr agenda")
-	crawlPathArg = crawlCmd.Arg("path", "output JSON path").Required().String()
-	crawlDumpDir = crawlCmd.Flag("dump-dir", "optionally dump page content").String()
+	crawlCmd      = app.Command("crawl", "crawl brest.fr agenda")
+	crawlPathArg  = crawlCmd.Arg("path", "output JSON path").Required().String()
+	crawlDumpDir  = crawlCmd.Flag("dump-dir", "optionally dump page content").String()
+	crawlWorkers  = crawlCmd.Flag("workers", "number of concurrent detail-page fetches").Default("4").Int()
+	crawlRps      = crawlCmd.Flag("rps", "maximum requests per second").Default("2").Float64()
+	crawlCacheArg = crawlCmd.Flag("cache", "sidecar file caching conditional GET validators").Default(".brestagenda.cache.json").String()
+	crawlEnrich   = crawlCmd.Flag("enrich", "follow each event's detail page to scrape venue/price/full description").Bool()
 )
 
 func crawlFn() error {
-	outPath := *crawlPathArg
 	dumpDir := ""
 	if crawlDumpDir != nil {
 		dumpDir = *crawlDumpDir
+	}
+	_, err := crawlOnce(*crawlPathArg, dumpDir, *crawlWorkers, *crawlRps, *crawlCacheArg, *crawlEnrich)
+	return err
+}
+
+// crawlOnce runs a full crawl, merges it into the JSON store at outPath
+// (see mergeEvents) and returns the merged events. It is shared by crawlFn
+// and the serve command's background refresher.
+func crawlOnce(outPath, dumpDir string, workers int, rps float64, cachePath string, enrich bool) (
+	[]Event, error) {
+
+	if dumpDir != "" {
 		err := os.MkdirAll(dumpDir, 0755)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
+	fetcher := crawler.NewFetcher(rps, cachePath)
+	defer fetcher.SaveCache()
+
 	baseUrl, err := url.Parse("https://www.brest.fr")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	path := "/actus-agenda/agenda-132.html"
 	events := []Event{}
@@ -151,18 +245,18 @@ func crawlFn() error {
 	for {
 		u, err := url.Parse(path)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		u = baseUrl.ResolveReference(u)
 		fmt.Println("GET", u)
-		p, err := getPage(client, u, baseUrl, dumpDir, pages)
+		p, err := getPage(fetcher, u, baseUrl, dumpDir, pages)
 		if err != nil {
 			if err == ServerError {
 				// Ignore 500 errors for now. There is one happening at each
 				// crawl and I cannot do anything about it.
 				break
 			}
-			return err
+			return nil, err
 		}
 		path = p.Next
 		events = append(events, p.Events...)
@@ -172,74 +266,69 @@ func crawlFn() error {
 		pages += 1
 	}
 	if len(events) == 0 {
-		return fmt.Errorf("no event found")
+		return nil, fmt.Errorf("no event found")
+	}
+	stored, err := loadEvents(outPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
 	}
+	if enrich {
+		enrichEvents(fetcher, workers, events, stored)
+	}
+	merged := mergeEvents(stored, events, time.Now())
 	fp, err := os.Create(outPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer fp.Close()
-	err = json.NewEncoder(fp).Encode(&events)
+	err = json.NewEncoder(fp).Encode(&merged)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return merged, nil
 }
 
-const PageTemplate = `
-<html>
-<header>
-	<meta charset="utf-8">
-	<title>Agenda Brest</title>
-	<style>
-	a:link {
-		text-decoration: none;
-	}
-
-	a:visited {
-		text-decoration: none;
+// mergeEvents folds freshly crawled events into the previously stored ones,
+// keyed by eventID, so the store accumulates history instead of being
+// overwritten on every crawl. FirstSeen is preserved across crawls, LastSeen
+// is bumped to now for every event still present, and Updated only moves
+// when the visible content actually changed. Stored events that the latest
+// crawl no longer finds (e.g. a past event that dropped off the agenda) are
+// carried forward unchanged, keeping their last known LastSeen, so the
+// store is a true archive rather than a snapshot of the current crawl.
+func mergeEvents(stored, crawled []Event, now time.Time) []Event {
+	byID := make(map[string]Event, len(stored))
+	for _, ev := range stored {
+		byID[ev.ID] = ev
 	}
-
-	a:hover {
-		text-decoration: underline;
+	seen := make(map[string]bool, len(crawled))
+	merged := make([]Event, 0, len(stored)+len(crawled))
+	for _, ev := range crawled {
+		ev.ID = eventID(&ev)
+		seen[ev.ID] = true
+		if prev, ok := byID[ev.ID]; ok {
+			ev.FirstSeen = prev.FirstSeen
+			ev.Updated = prev.Updated
+			if !sameContent(&ev, &prev) {
+				ev.Updated = now
+			}
+		} else {
+			ev.FirstSeen = now
+			ev.Updated = now
+		}
+		ev.LastSeen = now
+		merged = append(merged, ev)
 	}
-
-	a:active {
-			text-decoration: underline;
+	for _, ev := range stored {
+		if !seen[ev.ID] {
+			merged = append(merged, ev)
+		}
 	}
-	</style>
-</header>
-<body>
-<table>
-	{{range .Before}}
-	<tr>
-		<td style="white-space:nowrap">{{.Start}}</td>
-		<td>→</td>
-		<td style="white-space:nowrap">{{.End}}</td>
-		<td>[{{.Weekday}}]</td>
-		<td>{{.DeltaStr}}</td>
-		<td><a href="{{.Link}}">{{.Title}}</a></td>
-	</tr>
-	{{end}}
-	{{if .HasAfter}}
-</table>
-<hr id="now"></hr>
-<table>
-	{{end}}
-	{{range .After}}
-	<tr>
-		<td style="white-space:nowrap">{{.Start}}</td>
-		<td>→</td>
-		<td style="white-space:nowrap">{{.End}}</td>
-		<td>[{{.Weekday}}]</td>
-		<td>{{.DeltaStr}}</td>
-		<td><a href="{{.Link}}">{{.Title}}</a></td>
-	</tr>
-	{{end}}
-</table>
-</body>
-</html>
-`
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Start.Before(merged[j].Start)
+	})
+	return merged
+}
 
 type HtmlEntry struct {
 	Link     string
@@ -282,7 +371,7 @@ func formatDuration(days int) string {
 }
 
 func writeHtml(w io.Writer, events []Event) error {
-	t, err := template.New("html").Parse(PageTemplate)
+	t, err := template.New("page.html.tmpl").ParseFS(TemplatesFS, "templates/page.html.tmpl")
 	if err != nil {
 		return err
 	}
@@ -383,6 +472,16 @@ func dispatch() error {
 		return crawlFn()
 	case formatCmd.FullCommand():
 		return formatFn()
+	case icalCmd.FullCommand():
+		return icalFn()
+	case feedCmd.FullCommand():
+		return feedFn()
+	case indexCmd.FullCommand():
+		return indexFn()
+	case searchCmd.FullCommand():
+		return searchFn()
+	case serveCmd.FullCommand():
+		return serveFn()
 	}
 	return fmt.Errorf("unknown command: %s", cmd)
 }
@@ -0,0 +1,353 @@
+// Package crawler provides a small, site-agnostic worker-pool crawler:
+// polite rate limiting, robots.txt honoring, conditional GET caching and
+// User-Agent rotation. It knows nothing about the pages it fetches -
+// callers parse the returned bytes themselves, which keeps it testable
+// independently of any particular extractor.
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of fetching a single URL.
+type Result struct {
+	URL         string
+	Body        []byte
+	StatusCode  int
+	NotModified bool
+	Err         error
+}
+
+// defaultUserAgents is a small pool of realistic browser strings rotated on
+// each request, so the crawler does not look like a single bot hammering
+// the site with one fixed UA.
+var defaultUserAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+	"Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+}
+
+// RateLimiter is a simple token-bucket limiter used to cap requests per
+// second across all workers.
+type RateLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	last     time.Time
+}
+
+// NewRateLimiter returns a limiter allowing at most rps requests per
+// second. rps <= 0 disables limiting.
+func NewRateLimiter(rps float64) *RateLimiter {
+	if rps <= 0 {
+		return &RateLimiter{}
+	}
+	return &RateLimiter{interval: time.Duration(float64(time.Second) / rps)}
+}
+
+// Wait blocks until the next request is allowed to proceed.
+func (r *RateLimiter) Wait() {
+	if r.interval <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if wait := r.last.Add(r.interval).Sub(now); wait > 0 {
+		time.Sleep(wait)
+		now = now.Add(wait)
+	}
+	r.last = now
+}
+
+// cacheEntry remembers the validators returned for a URL so the next fetch
+// can be made conditional.
+type cacheEntry struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// Fetcher performs individual HTTP fetches, applying rate limiting,
+// robots.txt rules, conditional GET and User-Agent rotation.
+type Fetcher struct {
+	Client     *http.Client
+	Limiter    *RateLimiter
+	UserAgents []string
+	CachePath  string
+
+	mu     sync.Mutex
+	cache  map[string]cacheEntry
+	robots map[string]*robotsRules
+}
+
+// NewFetcher builds a Fetcher rate-limited to rps requests/second. If
+// cachePath is non-empty, conditional GET validators are persisted there
+// across runs.
+func NewFetcher(rps float64, cachePath string) *Fetcher {
+	f := &Fetcher{
+		Client:     &http.Client{Timeout: 30 * time.Second},
+		Limiter:    NewRateLimiter(rps),
+		UserAgents: defaultUserAgents,
+		CachePath:  cachePath,
+		cache:      map[string]cacheEntry{},
+		robots:     map[string]*robotsRules{},
+	}
+	f.loadCache()
+	return f
+}
+
+func (f *Fetcher) loadCache() error {
+	if f.CachePath == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(f.CachePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, &f.cache)
+}
+
+// SaveCache persists conditional-GET validators gathered so far. Callers
+// should call it once after a crawl completes.
+func (f *Fetcher) SaveCache() error {
+	if f.CachePath == "" {
+		return nil
+	}
+	f.mu.Lock()
+	data, err := json.Marshal(f.cache)
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f.CachePath, data, 0644)
+}
+
+func (f *Fetcher) pickUserAgent() string {
+	agents := f.UserAgents
+	if len(agents) == 0 {
+		agents = defaultUserAgents
+	}
+	return agents[rand.Intn(len(agents))]
+}
+
+// Allowed reports whether rawURL may be fetched per the target host's
+// robots.txt, fetching and caching the rules on first use for that host.
+func (f *Fetcher) Allowed(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return true
+	}
+	rules := f.robotsFor(u)
+	if rules == nil {
+		return true
+	}
+	return rules.allows(u.Path)
+}
+
+func (f *Fetcher) robotsFor(u *url.URL) *robotsRules {
+	host := u.Scheme + "://" + u.Host
+	f.mu.Lock()
+	if r, ok := f.robots[host]; ok {
+		f.mu.Unlock()
+		return r
+	}
+	f.mu.Unlock()
+
+	rules := fetchRobots(f.Client, host)
+	f.mu.Lock()
+	f.robots[host] = rules
+	f.mu.Unlock()
+	return rules
+}
+
+// Fetch retrieves rawURL, applying rate limiting, robots.txt and
+// conditional GET. A 304 response is reported as NotModified with no body;
+// callers that cannot make sense of an empty body for an unchanged
+// resource (e.g. they need to re-derive a "next page" link) should use
+// FetchFresh instead.
+func (f *Fetcher) Fetch(rawURL string) Result {
+	return f.fetch(rawURL, true)
+}
+
+// FetchFresh retrieves rawURL like Fetch, but never sends conditional
+// headers and never reports NotModified, so the caller always gets a full
+// body back. Use it for pages whose content must be parsed on every visit
+// (e.g. paginated listings, where a 304 would otherwise break pagination).
+func (f *Fetcher) FetchFresh(rawURL string) Result {
+	return f.fetch(rawURL, false)
+}
+
+func (f *Fetcher) fetch(rawURL string, conditional bool) Result {
+	if !f.Allowed(rawURL) {
+		return Result{URL: rawURL, Err: ErrDisallowed}
+	}
+	f.Limiter.Wait()
+
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return Result{URL: rawURL, Err: err}
+	}
+	req.Header.Set("User-Agent", f.pickUserAgent())
+
+	if conditional {
+		f.mu.Lock()
+		entry := f.cache[rawURL]
+		f.mu.Unlock()
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	rsp, err := f.Client.Do(req)
+	if err != nil {
+		return Result{URL: rawURL, Err: err}
+	}
+	defer rsp.Body.Close()
+
+	if conditional && rsp.StatusCode == http.StatusNotModified {
+		return Result{URL: rawURL, StatusCode: rsp.StatusCode, NotModified: true}
+	}
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return Result{URL: rawURL, Err: err}
+	}
+
+	// Fresh fetches don't participate in the conditional-GET cache.
+	if conditional {
+		if etag := rsp.Header.Get("ETag"); etag != "" {
+			f.mu.Lock()
+			f.cache[rawURL] = cacheEntry{ETag: etag, LastModified: rsp.Header.Get("Last-Modified")}
+			f.mu.Unlock()
+		} else if lm := rsp.Header.Get("Last-Modified"); lm != "" {
+			f.mu.Lock()
+			f.cache[rawURL] = cacheEntry{LastModified: lm}
+			f.mu.Unlock()
+		}
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		return Result{URL: rawURL, StatusCode: rsp.StatusCode, Body: body,
+			Err: &statusError{rsp.StatusCode}}
+	}
+	return Result{URL: rawURL, StatusCode: rsp.StatusCode, Body: body}
+}
+
+type statusError struct{ code int }
+
+func (e *statusError) Error() string {
+	return "unexpected status code " + http.StatusText(e.code)
+}
+
+// ErrDisallowed is returned by Fetch when robots.txt forbids the URL.
+var ErrDisallowed = &statusError{http.StatusForbidden}
+
+// Crawler fans a list of URLs out to a fixed pool of workers, each calling
+// Fetcher.Fetch, and collects the results in input order.
+type Crawler struct {
+	Fetcher *Fetcher
+	Workers int
+}
+
+// New returns a Crawler backed by fetcher, using the given number of
+// concurrent workers (at least 1).
+func New(fetcher *Fetcher, workers int) *Crawler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Crawler{Fetcher: fetcher, Workers: workers}
+}
+
+// FetchAll fetches every URL, at most c.Workers at a time, and returns the
+// results in the same order as urls.
+func (c *Crawler) FetchAll(urls []string) []Result {
+	results := make([]Result, len(urls))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx] = c.Fetcher.Fetch(urls[idx])
+			}
+		}()
+	}
+	for i := range urls {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// robotsRules is a minimal robots.txt representation: the Disallow
+// prefixes of the `*` group. Groups naming a specific User-Agent are
+// ignored.
+type robotsRules struct {
+	disallow []string
+}
+
+func (r *robotsRules) allows(path string) bool {
+	if r == nil {
+		return true
+	}
+	for _, prefix := range r.disallow {
+		if prefix != "" && strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchRobots downloads and parses host+"/robots.txt". Any failure is
+// treated as "no rules", i.e. everything allowed.
+func fetchRobots(client *http.Client, host string) *robotsRules {
+	rsp, err := client.Get(host + "/robots.txt")
+	if err != nil {
+		return &robotsRules{}
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return &robotsRules{}
+	}
+
+	rules := &robotsRules{}
+	applies := false
+	scanner := bufio.NewScanner(rsp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		field := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		switch field {
+		case "user-agent":
+			applies = value == "*"
+		case "disallow":
+			if applies {
+				rules.disallow = append(rules.disallow, value)
+			}
+		}
+	}
+	return rules
+}
@@ -0,0 +1,130 @@
+package crawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRobotsRulesAllows(t *testing.T) {
+	rules := &robotsRules{disallow: []string{"/admin", "/private/"}}
+
+	cases := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/agenda-132.html", true},
+		{"/admin", false},
+		{"/admin/users", false},
+		{"/private/", false},
+	}
+	for _, c := range cases {
+		if got := rules.allows(c.path); got != c.want {
+			t.Errorf("allows(%q) = %v, want %v", c.path, got, c.want)
+		}
+	}
+}
+
+func TestRobotsRulesAllowsNilIsPermissive(t *testing.T) {
+	var rules *robotsRules
+	if !rules.allows("/anything") {
+		t.Fatal("nil robotsRules should allow everything")
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	rl := NewRateLimiter(10) // one request every 100ms
+
+	start := time.Now()
+	rl.Wait()
+	rl.Wait()
+	elapsed := time.Since(start)
+
+	if elapsed < 100*time.Millisecond {
+		t.Errorf("Wait returned too early: elapsed %v, want >= 100ms", elapsed)
+	}
+}
+
+func TestRateLimiterWaitDisabled(t *testing.T) {
+	rl := NewRateLimiter(0)
+
+	start := time.Now()
+	rl.Wait()
+	rl.Wait()
+	elapsed := time.Since(start)
+
+	if elapsed > 50*time.Millisecond {
+		t.Errorf("Wait with rps<=0 should not block, elapsed %v", elapsed)
+	}
+}
+
+func TestFetchNotModified(t *testing.T) {
+	const etag = `"abc123"`
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	f := NewFetcher(0, "")
+
+	first := f.Fetch(srv.URL)
+	if first.Err != nil {
+		t.Fatalf("first fetch failed: %v", first.Err)
+	}
+	if first.NotModified {
+		t.Fatal("first fetch should not be reported as NotModified")
+	}
+	if string(first.Body) != "hello" {
+		t.Fatalf("first fetch body = %q, want %q", first.Body, "hello")
+	}
+
+	second := f.Fetch(srv.URL)
+	if second.Err != nil {
+		t.Fatalf("second fetch failed: %v", second.Err)
+	}
+	if !second.NotModified {
+		t.Fatal("second fetch should be reported as NotModified")
+	}
+	if len(second.Body) != 0 {
+		t.Fatalf("second fetch body = %q, want empty", second.Body)
+	}
+	// The first Fetch also looks up robots.txt on the same server.
+	if requests != 3 {
+		t.Fatalf("server saw %d requests, want 3 (robots.txt + 2 fetches)", requests)
+	}
+}
+
+func TestFetchFreshIgnoresCache(t *testing.T) {
+	const etag = `"abc123"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" {
+			t.Errorf("FetchFresh should not send conditional headers, got If-None-Match: %q", r.Header.Get("If-None-Match"))
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	f := NewFetcher(0, "")
+	f.Fetch(srv.URL) // populate the cache entry
+
+	res := f.FetchFresh(srv.URL)
+	if res.Err != nil {
+		t.Fatalf("FetchFresh failed: %v", res.Err)
+	}
+	if res.NotModified {
+		t.Fatal("FetchFresh should never report NotModified")
+	}
+	if string(res.Body) != "hello" {
+		t.Fatalf("FetchFresh body = %q, want %q", res.Body, "hello")
+	}
+}
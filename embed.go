@@ -0,0 +1,12 @@
+package main
+
+import "embed"
+
+// TemplatesFS holds the template sources used for the HTML export (format
+// command, parsed with text/template) and the serve command's views
+// (parsed with html/template). Keeping them as files
+// under templates/ rather than Go string constants lets them be overridden
+// by rebuilding with a different templates/ directory.
+//
+//go:embed templates/*.tmpl
+var TemplatesFS embed.FS
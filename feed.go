@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"sort"
+)
+
+// Atom 1.0 (RFC 4287) feed structures, populated from the event store.
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+// atomAuthor is the feed-level author (RFC 4287 4.2.1 requires every entry
+// or the feed itself to carry one).
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string   `xml:"title"`
+	ID        string   `xml:"id"`
+	Link      atomLink `xml:"link"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+	Content   string   `xml:"content"`
+}
+
+const atomTimeLayout = "2006-01-02T15:04:05Z"
+
+// atomEntryID builds a tag URI (RFC 4151) identifying the event, rooted at
+// the year it was first seen so the ID stays valid if the site URL scheme
+// changes later.
+func atomEntryID(ev *Event) string {
+	year := ev.FirstSeen.Format("2006")
+	if ev.FirstSeen.IsZero() {
+		year = ev.Start.Format("2006")
+	}
+	return "tag:brest.fr," + year + ":" + eventID(ev)
+}
+
+// writeFeed writes the `count` most recently added or changed events as an
+// Atom 1.0 feed, newest Updated first.
+func writeFeed(w io.Writer, events []Event, feedLink string, count int) error {
+	sorted := make([]Event, len(events))
+	copy(sorted, events)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Updated.After(sorted[j].Updated)
+	})
+	if count > 0 && len(sorted) > count {
+		sorted = sorted[:count]
+	}
+	feed := atomFeed{
+		Title:  "Agenda Brest",
+		ID:     "tag:brest.fr,2026:brestagenda",
+		Link:   atomLink{Href: feedLink},
+		Author: atomAuthor{Name: "Agenda Brest"},
+	}
+	var latest string
+	for i := range sorted {
+		ev := &sorted[i]
+		if ev.Updated.Format(atomTimeLayout) > latest {
+			latest = ev.Updated.Format(atomTimeLayout)
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     ev.Title,
+			ID:        atomEntryID(ev),
+			Link:      atomLink{Href: ev.Link},
+			Published: ev.FirstSeen.Format(atomTimeLayout),
+			Updated:   ev.Updated.Format(atomTimeLayout),
+			Content:   ev.Desc,
+		})
+	}
+	feed.Updated = latest
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(&feed)
+}
+
+var (
+	feedCmd      = app.Command("feed", "write recently added/changed events as an Atom feed")
+	feedJsonArg  = feedCmd.Arg("json", "input JSON path").Required().String()
+	feedPathArg  = feedCmd.Arg("path", "output Atom path").Required().String()
+	feedLinkFlag = feedCmd.Flag("link", "feed link, to the page the feed describes").Default("https://www.brest.fr/actus-agenda/agenda-132.html").String()
+	feedCount    = feedCmd.Flag("count", "number of entries to include").Default("20").Int()
+)
+
+func feedFn() error {
+	events, err := loadEvents(*feedJsonArg)
+	if err != nil {
+		return err
+	}
+	fp, err := os.Create(*feedPathArg)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return writeFeed(fp, events, *feedLinkFlag, *feedCount)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// icsDateLayout is the "date" form of iCalendar DATE values (RFC 5545
+// 3.3.4), used here for all-day VEVENTs.
+const icsDateLayout = "20060102"
+
+// icsFoldWriter wraps an io.Writer and folds content lines longer than 75
+// octets onto continuation lines starting with a single space, as required
+// by RFC 5545 3.1. Lines are terminated with CRLF.
+type icsFoldWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (f *icsFoldWriter) writeLine(line string) {
+	if f.err != nil {
+		return
+	}
+	const maxOctets = 75
+	first := true
+	for len(line) > 0 {
+		prefix := ""
+		budget := maxOctets
+		if !first {
+			prefix = " "
+			budget--
+		}
+		n := budget
+		if n >= len(line) {
+			n = len(line)
+		} else {
+			// Back off to the nearest rune boundary so we never split a
+			// multi-octet UTF-8 sequence across a fold.
+			for n > 0 && !utf8.RuneStart(line[n]) {
+				n--
+			}
+		}
+		_, f.err = io.WriteString(f.w, prefix+line[:n]+"\r\n")
+		if f.err != nil {
+			return
+		}
+		line = line[n:]
+		first = false
+	}
+}
+
+// icsEscape escapes text per RFC 5545 3.3.11 for use in TEXT properties.
+func icsEscape(s string) string {
+	r := strings.NewReplacer(
+		"\\", "\\\\",
+		";", "\\;",
+		",", "\\,",
+		"\n", "\\n",
+	)
+	return r.Replace(s)
+}
+
+// eventUID derives a stable VEVENT UID from the event ID, so re-crawling
+// the same event keeps producing the same identifier.
+func eventUID(ev *Event) string {
+	return fmt.Sprintf("%s@brestagenda", eventID(ev))
+}
+
+// writeIcal writes events as a single RFC 5545 VCALENDAR to w, one VEVENT
+// per event, as all-day entries.
+func writeIcal(w io.Writer, events []Event) error {
+	f := &icsFoldWriter{w: w}
+	f.writeLine("BEGIN:VCALENDAR")
+	f.writeLine("VERSION:2.0")
+	f.writeLine("PRODID:-//brestagenda//brestagenda//EN")
+	f.writeLine("CALSCALE:GREGORIAN")
+	now := time.Now().UTC().Format("20060102T150405Z")
+	for i := range events {
+		ev := &events[i]
+		end := ev.End
+		if end.IsZero() {
+			end = ev.Start
+		}
+		end = end.AddDate(0, 0, 1)
+		f.writeLine("BEGIN:VEVENT")
+		f.writeLine("UID:" + eventUID(ev))
+		f.writeLine("DTSTAMP:" + now)
+		f.writeLine("DTSTART;VALUE=DATE:" + ev.Start.Format(icsDateLayout))
+		f.writeLine("DTEND;VALUE=DATE:" + end.Format(icsDateLayout))
+		f.writeLine("SUMMARY:" + icsEscape(ev.Title))
+		if ev.Desc != "" {
+			f.writeLine("DESCRIPTION:" + icsEscape(ev.Desc))
+		}
+		if ev.Category != "" {
+			f.writeLine("CATEGORIES:" + icsEscape(ev.Category))
+		}
+		if ev.Link != "" {
+			f.writeLine("URL:" + ev.Link)
+		}
+		f.writeLine("END:VEVENT")
+	}
+	f.writeLine("END:VCALENDAR")
+	return f.err
+}
+
+var (
+	icalCmd     = app.Command("ical", "write agenda events as an iCalendar feed")
+	icalJsonArg = icalCmd.Arg("json", "input JSON path").Required().String()
+	icalPathArg = icalCmd.Arg("path", "output ICS path").Required().String()
+)
+
+func icalFn() error {
+	events, err := loadEvents(*icalJsonArg)
+	if err != nil {
+		return err
+	}
+	fp, err := os.Create(*icalPathArg)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	bw := bufio.NewWriter(fp)
+	if err := writeIcal(bw, events); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+// indexDoc is the document shape stored in the Bleve index for each Event.
+// It only carries the fields events are searched and faceted on; the
+// Link is kept so search results can point back at the event's page.
+type indexDoc struct {
+	Title    string    `json:"title"`
+	Desc     string    `json:"desc"`
+	Category string    `json:"category"`
+	Link     string    `json:"link"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+}
+
+// buildIndexMapping configures Start/End as datetime fields and Category as
+// an untokenized field so it can be used as a facet.
+func buildIndexMapping() mapping.IndexMapping {
+	dateField := bleve.NewDateTimeFieldMapping()
+
+	categoryField := bleve.NewTextFieldMapping()
+	categoryField.Analyzer = "keyword"
+
+	docMapping := bleve.NewDocumentMapping()
+	docMapping.AddFieldMappingsAt("start", dateField)
+	docMapping.AddFieldMappingsAt("end", dateField)
+	docMapping.AddFieldMappingsAt("category", categoryField)
+
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.DefaultMapping = docMapping
+	return indexMapping
+}
+
+// openOrCreateIndex opens indexDir if it already holds a Bleve index, or
+// creates a new one there otherwise, so `index` can be rerun incrementally.
+func openOrCreateIndex(indexDir string) (bleve.Index, error) {
+	idx, err := bleve.Open(indexDir)
+	if err == nil {
+		return idx, nil
+	}
+	if err != bleve.ErrorIndexPathDoesNotExist {
+		return nil, err
+	}
+	return bleve.New(indexDir, buildIndexMapping())
+}
+
+func toIndexDoc(ev *Event) indexDoc {
+	return indexDoc{
+		Title:    ev.Title,
+		Desc:     ev.Desc,
+		Category: ev.Category,
+		Link:     ev.Link,
+		Start:    ev.Start,
+		End:      ev.End,
+	}
+}
+
+var (
+	indexCmd     = app.Command("index", "build or update the full-text search index")
+	indexJsonArg = indexCmd.Arg("json", "input JSON path").Required().String()
+	indexDirArg  = indexCmd.Arg("indexdir", "Bleve index directory").Required().String()
+)
+
+func indexFn() error {
+	events, err := loadEvents(*indexJsonArg)
+	if err != nil {
+		return err
+	}
+	idx, err := openOrCreateIndex(*indexDirArg)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	batch := idx.NewBatch()
+	for i := range events {
+		ev := &events[i]
+		id := eventID(ev)
+		if err := batch.Index(id, toIndexDoc(ev)); err != nil {
+			return err
+		}
+	}
+	return idx.Batch(batch)
+}
+
+var (
+	searchCmd      = app.Command("search", "search the full-text index")
+	searchDirArg   = searchCmd.Arg("indexdir", "Bleve index directory").Required().String()
+	searchQueryArg = searchCmd.Arg("query", "search query").Required().String()
+	searchLimit    = searchCmd.Flag("limit", "maximum number of results").Default("20").Int()
+)
+
+// dateRangeRe matches the `field:>date` / `field:<date` clauses the request
+// asks for (e.g. "start:>2025-01-01"), which Bleve's own query string syntax
+// has no direct equivalent for.
+var dateRangeRe = regexp.MustCompile(`(?i)\b(start|end):([<>])(\d{4}-\d{2}-\d{2})\b`)
+
+// buildSearchQuery turns the free-form query text into a conjunction of a
+// query-string query (for the remaining free text) and any date-range
+// clauses it finds.
+func buildSearchQuery(q string) (bleve.Query, error) {
+	var ranges []bleve.Query
+	rest := dateRangeRe.ReplaceAllStringFunc(q, func(m string) string {
+		parts := dateRangeRe.FindStringSubmatch(m)
+		field, op, dateStr := parts[1], parts[2], parts[3]
+		date, err := time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			return m
+		}
+		rq := bleve.NewDateRangeQuery(time.Time{}, time.Time{})
+		rq.SetField(field)
+		if op == ">" {
+			rq.Start = date
+		} else {
+			rq.End = date
+		}
+		ranges = append(ranges, rq)
+		return ""
+	})
+	rest = strings.TrimSpace(rest)
+
+	conjuncts := append([]bleve.Query{}, ranges...)
+	if rest != "" {
+		conjuncts = append(conjuncts, bleve.NewQueryStringQuery(rest))
+	}
+	switch len(conjuncts) {
+	case 0:
+		return bleve.NewMatchAllQuery(), nil
+	case 1:
+		return conjuncts[0], nil
+	default:
+		return bleve.NewConjunctionQuery(conjuncts...), nil
+	}
+}
+
+func searchFn() error {
+	idx, err := bleve.Open(*searchDirArg)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	query, err := buildSearchQuery(*searchQueryArg)
+	if err != nil {
+		return err
+	}
+	req := bleve.NewSearchRequest(query)
+	req.Size = *searchLimit
+	req.Fields = []string{"title", "category", "link"}
+	req.Highlight = bleve.NewHighlight()
+	req.AddFacet("category", bleve.NewFacetRequest("category", 10))
+
+	res, err := idx.Search(req)
+	if err != nil {
+		return err
+	}
+	for _, hit := range res.Hits {
+		fmt.Printf("%s [%s]\n", hit.Fields["title"], hit.Fields["category"])
+		fmt.Printf("  %s\n", hit.Fields["link"])
+		for field, fragments := range hit.Fragments {
+			for _, frag := range fragments {
+				fmt.Printf("  %s: %s\n", field, frag)
+			}
+		}
+	}
+	if facet, ok := res.Facets["category"]; ok {
+		fmt.Println("categories:")
+		for _, term := range facet.Terms.Terms() {
+			fmt.Printf("  %s (%d)\n", term.Term, term.Count)
+		}
+	}
+	return nil
+}
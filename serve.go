@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventStore holds the crawled events in memory, refreshed on an interval
+// by a background goroutine, and a Modified timestamp used for
+// Last-Modified/ETag.
+type eventStore struct {
+	mu       sync.RWMutex
+	events   []Event
+	modified time.Time
+}
+
+func (s *eventStore) set(events []Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = events
+	s.modified = time.Now()
+}
+
+func (s *eventStore) get() ([]Event, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.events, s.modified
+}
+
+// etag derives a validator from the store's modification time and extra,
+// which callers use to fold in anything else that changes the response
+// body for the same store state (e.g. a filtered route's query string).
+func (s *eventStore) etag(extra string) string {
+	_, modified := s.get()
+	return fmt.Sprintf(`"%x"`, sha1.Sum([]byte(modified.Format(time.RFC3339Nano)+"|"+extra)))
+}
+
+// checkFreshness replies 304 and returns true if the request's
+// If-None-Match/If-Modified-Since matches the store's current state. extra
+// must capture anything other than store.modified that the response
+// depends on (see etag); if non-empty, only the (stronger) ETag check is
+// used, since Last-Modified alone cannot distinguish two representations
+// sharing the same store state.
+func checkFreshness(w http.ResponseWriter, r *http.Request, s *eventStore, extra string) bool {
+	_, modified := s.get()
+	etag := s.etag(extra)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	if extra == "" {
+		if since := r.Header.Get("If-Modified-Since"); since != "" {
+			if t, err := http.ParseTime(since); err == nil && !modified.After(t) {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// refreshLoop re-crawls into outPath every interval and swaps the result
+// into the store. Crawl errors are logged and leave the previous events in
+// place.
+func refreshLoop(store *eventStore, outPath string, workers int, rps float64, cachePath string, interval time.Duration) {
+	for {
+		events, err := crawlOnce(outPath, "", workers, rps, cachePath, false)
+		if err != nil {
+			log.Println("refresh failed:", err)
+		} else {
+			store.set(events)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// eventFilter narrows the served events down to a category and/or date
+// window, all optional.
+type eventFilter struct {
+	Category string
+	From     string
+	To       string
+}
+
+func (f eventFilter) apply(events []Event) []Event {
+	var from, to time.Time
+	if f.From != "" {
+		from, _ = time.Parse("2006-01-02", f.From)
+	}
+	if f.To != "" {
+		to, _ = time.Parse("2006-01-02", f.To)
+	}
+	filtered := make([]Event, 0, len(events))
+	for _, ev := range events {
+		if f.Category != "" && ev.Category != f.Category {
+			continue
+		}
+		if !from.IsZero() && ev.Start.Before(from) {
+			continue
+		}
+		if !to.IsZero() && ev.Start.After(to) {
+			continue
+		}
+		filtered = append(filtered, ev)
+	}
+	return filtered
+}
+
+func categoriesOf(events []Event) []string {
+	seen := map[string]bool{}
+	var categories []string
+	for _, ev := range events {
+		if ev.Category != "" && !seen[ev.Category] {
+			seen[ev.Category] = true
+			categories = append(categories, ev.Category)
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// indexEntry is an HtmlEntry plus the event ID, so the index template can
+// link to /events/{id} instead of the event's external Link.
+type indexEntry struct {
+	HtmlEntry
+	ID string
+}
+
+// toHtmlEntries mirrors writeHtml's before/after split, but keyed by
+// event ID rather than a direct link, so the server can route to
+// /events/{id}.
+func toHtmlEntries(events []Event) (before, after []indexEntry, hasAfter bool) {
+	now := time.Now()
+	now = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	var befores, afters []indexEntry
+	for _, ev := range events {
+		startDate := ev.Start
+		endDate := ev.Start.Add(24 * time.Hour)
+		if !ev.End.IsZero() {
+			endDate = ev.End.Add(24 * time.Hour)
+		}
+		endDateIn := endDate.Add(-24 * time.Hour)
+		if !now.Before(endDate) {
+			continue
+		}
+		relDate := startDate
+		mult := 1
+		if startDate.Before(now) {
+			relDate = endDate
+			mult = -1
+		}
+		delta := mult * int(relDate.Sub(now).Hours()/24)
+		deltaStr := ""
+		if delta != 0 {
+			deltaStr = formatDuration(delta)
+		}
+		e := indexEntry{
+			HtmlEntry: HtmlEntry{
+				Link:     ev.Link,
+				Start:    ev.Start.Format("2006-01-02"),
+				End:      endDateIn.Format("2006-01-02"),
+				DeltaStr: deltaStr,
+				Delta:    delta,
+				Title:    ev.Title,
+				Weekday:  Weekdays[int(relDate.Weekday())],
+			},
+			ID: ev.ID,
+		}
+		if !startDate.Before(now) {
+			afters = append(afters, e)
+		} else {
+			befores = append(befores, e)
+		}
+	}
+	sort.Slice(befores, func(i, j int) bool { return befores[i].Delta < befores[j].Delta })
+	sort.Slice(afters, func(i, j int) bool { return afters[i].Delta < afters[j].Delta })
+	return befores, afters, len(befores) > 0 && len(afters) > 0
+}
+
+func serveIndex(store *eventStore, indexTmpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := eventFilter{
+			Category: r.URL.Query().Get("category"),
+			From:     r.URL.Query().Get("from"),
+			To:       r.URL.Query().Get("to"),
+		}
+		// toHtmlEntries buckets events relative to today's day boundary, so
+		// that must be part of the cache key alongside the filter: the same
+		// store state renders differently once the day rolls over.
+		today := time.Now().Format("2006-01-02")
+		extra := filter.Category + "|" + filter.From + "|" + filter.To + "|" + today
+		if checkFreshness(w, r, store, extra) {
+			return
+		}
+		events, _ := store.get()
+		filtered := filter.apply(events)
+		before, after, hasAfter := toHtmlEntries(filtered)
+
+		data := struct {
+			Before     []indexEntry
+			After      []indexEntry
+			HasAfter   bool
+			Categories []string
+			Filter     eventFilter
+		}{
+			Before:     before,
+			After:      after,
+			HasAfter:   hasAfter,
+			Categories: categoriesOf(events),
+			Filter:     filter,
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := indexTmpl.Execute(w, &data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func serveEventsJson(store *eventStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkFreshness(w, r, store, "") {
+			return
+		}
+		events, _ := store.get()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&events); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func serveEventsIcal(store *eventStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkFreshness(w, r, store, "") {
+			return
+		}
+		events, _ := store.get()
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		if err := writeIcal(w, events); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func serveEventDetail(store *eventStore, eventTmpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/events/")
+		if id == "" {
+			http.NotFound(w, r)
+			return
+		}
+		events, _ := store.get()
+		for i := range events {
+			if events[i].ID == id {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				if err := eventTmpl.Execute(w, &events[i]); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}
+}
+
+var (
+	serveCmd      = app.Command("serve", "serve the agenda over HTTP, refreshing on a schedule")
+	servePathArg  = serveCmd.Arg("path", "JSON store path").Required().String()
+	serveAddr     = serveCmd.Flag("addr", "listen address").Default(":8080").String()
+	serveRefresh  = serveCmd.Flag("refresh", "crawl interval").Default("6h").Duration()
+	serveWorkers  = serveCmd.Flag("workers", "number of concurrent detail-page fetches (no effect: the refresh crawl does not follow detail pages)").Default("4").Int()
+	serveRps      = serveCmd.Flag("rps", "maximum requests per second").Default("2").Float64()
+	serveCacheArg = serveCmd.Flag("cache", "sidecar file caching conditional GET validators").Default(".brestagenda.cache.json").String()
+)
+
+func serveFn() error {
+	store := &eventStore{}
+	if events, err := loadEvents(*servePathArg); err == nil {
+		store.set(events)
+	}
+	go refreshLoop(store, *servePathArg, *serveWorkers, *serveRps, *serveCacheArg, *serveRefresh)
+
+	indexTmpl, err := template.New("index.html.tmpl").ParseFS(TemplatesFS, "templates/index.html.tmpl")
+	if err != nil {
+		return err
+	}
+	eventTmpl, err := template.New("event.html.tmpl").ParseFS(TemplatesFS, "templates/event.html.tmpl")
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", serveIndex(store, indexTmpl))
+	mux.HandleFunc("/events.json", serveEventsJson(store))
+	mux.HandleFunc("/events.ics", serveEventsIcal(store))
+	mux.HandleFunc("/events/", serveEventDetail(store, eventTmpl))
+
+	log.Println("listening on", *serveAddr)
+	return http.ListenAndServe(*serveAddr, mux)
+}